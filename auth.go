@@ -0,0 +1,282 @@
+package endpoints
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/user"
+)
+
+// User identifies the authenticated caller of an endpoint.
+type User struct {
+	ID    string
+	Email string
+}
+
+// Authenticator resolves the User for an in-flight request, or returns an
+// error if the request doesn't carry valid credentials for this mechanism.
+// Server.RegisterService runs a list of these in order, accepting the first
+// one that succeeds.
+type Authenticator interface {
+	CurrentUser(c context.Context) (*User, error)
+}
+
+// authSettings configures authentication for a single endpoint method.
+type authSettings struct {
+	authenticators []Authenticator
+	required       bool
+}
+
+// authenticate runs authenticators in order, returning the first resolved
+// User. If none succeed it returns the last error encountered.
+func authenticate(authenticators []Authenticator, c context.Context) (*User, error) {
+	var lastErr error
+	for _, a := range authenticators {
+		u, err := a.CurrentUser(c)
+		if err == nil && u != nil {
+			return u, nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("endpoints: no credentials provided")
+	}
+	return nil, lastErr
+}
+
+// CurrentUser returns the User resolved by the endpoint's Authenticator
+// chain, or nil if the endpoint has no authenticators configured, or the
+// request was anonymous on a non-required endpoint.
+func CurrentUser(c context.Context) *User {
+	u, _ := c.Value(authenticatorKey).(*User)
+	return u
+}
+
+// AppEngineOAuthAuthenticator authenticates requests carrying an App Engine
+// OAuth2 access token, via user.CurrentOAuth.
+type AppEngineOAuthAuthenticator struct {
+	// Scopes restricts which OAuth2 scopes are accepted; nil accepts any
+	// scope the token was granted.
+	Scopes []string
+}
+
+func (a *AppEngineOAuthAuthenticator) CurrentUser(c context.Context) (*User, error) {
+	u, err := user.CurrentOAuth(c, a.Scopes...)
+	if err != nil {
+		return nil, err
+	}
+	return &User{ID: u.ID, Email: u.Email}, nil
+}
+
+const (
+	defaultGoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+	googleIssuerShort    = "accounts.google.com"
+	googleIssuerFull     = "https://accounts.google.com"
+	jwksCacheTTL         = time.Hour
+)
+
+// GoogleIDTokenAuthenticator validates Google-issued ID tokens sent as
+// "Authorization: Bearer <token>", verifying their signature against keys
+// fetched from Google's JWKS endpoint.
+type GoogleIDTokenAuthenticator struct {
+	// Audience, if set, must match the token's "aud" claim.
+	Audience string
+
+	// JWKSURL overrides Google's public certs endpoint; useful for tests.
+	JWKSURL string
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	keysExp time.Time
+}
+
+func (a *GoogleIDTokenAuthenticator) CurrentUser(c context.Context) (*User, error) {
+	r := HTTPRequest(c)
+	if r == nil {
+		return nil, errors.New("endpoints: no request on context")
+	}
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errors.New("endpoints: missing bearer token")
+	}
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return &User{ID: claims.Subject, Email: claims.Email}, nil
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(h, prefix)
+}
+
+// googleIDTokenClaims is the subset of a Google ID token's payload this
+// package cares about.
+type googleIDTokenClaims struct {
+	Issuer   string `json:"iss"`
+	Audience string `json:"aud"`
+	Subject  string `json:"sub"`
+	Email    string `json:"email"`
+	Expiry   int64  `json:"exp"`
+}
+
+func (a *GoogleIDTokenAuthenticator) verify(token string) (*googleIDTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("endpoints: malformed ID token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("endpoints: decode token header: %v", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("endpoints: parse token header: %v", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("endpoints: unsupported token algorithm %q", header.Alg)
+	}
+
+	key, err := a.publicKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("endpoints: decode token signature: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("endpoints: invalid token signature: %v", err)
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("endpoints: decode token payload: %v", err)
+	}
+	var claims googleIDTokenClaims
+	if err := json.Unmarshal(payloadJSON, &claims); err != nil {
+		return nil, fmt.Errorf("endpoints: parse token payload: %v", err)
+	}
+	if claims.Issuer != googleIssuerShort && claims.Issuer != googleIssuerFull {
+		return nil, fmt.Errorf("endpoints: unexpected token issuer %q", claims.Issuer)
+	}
+	if a.Audience != "" && claims.Audience != a.Audience {
+		return nil, errors.New("endpoints: token audience mismatch")
+	}
+	if time.Unix(claims.Expiry, 0).Before(time.Now()) {
+		return nil, errors.New("endpoints: token expired")
+	}
+	return &claims, nil
+}
+
+func (a *GoogleIDTokenAuthenticator) publicKey(kid string) (*rsa.PublicKey, error) {
+	a.mu.Lock()
+	key, ok := a.keys[kid]
+	fresh := time.Now().Before(a.keysExp)
+	a.mu.Unlock()
+	if ok && fresh {
+		return key, nil
+	}
+
+	// fetchGoogleJWKS is a network call; don't hold the lock across it or
+	// every request stalls behind whichever goroutine lost the cache race,
+	// for as long as Google's JWKS endpoint takes to respond (or longer, if
+	// it's unreachable).
+	url := a.JWKSURL
+	if url == "" {
+		url = defaultGoogleJWKSURL
+	}
+	keys, err := fetchGoogleJWKS(url)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.keys = keys
+	a.keysExp = time.Now().Add(jwksCacheTTL)
+	a.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("endpoints: no matching JWKS key for kid %q", kid)
+	}
+	return key, nil
+}
+
+// googleJWK is a single entry in Google's JWKS response.
+type googleJWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// jwksHTTPClient bounds how long a cache-miss can block a request on
+// Google's JWKS endpoint; the stdlib's default client has no timeout at all.
+var jwksHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func fetchGoogleJWKS(url string) (map[string]*rsa.PublicKey, error) {
+	resp, err := jwksHTTPClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("endpoints: fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Keys []googleJWK `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("endpoints: decode JWKS: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(body.Keys))
+	for _, k := range body.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := k.rsaPublicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func (k googleJWK) rsaPublicKey() (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
@@ -0,0 +1,143 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Codec encodes and decodes the request and response payloads dispatched by
+// EndpointHandlerWrapper. The wrapper always hands Decode a freshly
+// allocated pointer (via reflect.New), so implementations can type-assert
+// to whatever concrete shape they require (e.g. proto.Message).
+type Codec interface {
+	Decode(r io.Reader, v interface{}) error
+	Encode(w io.Writer, v interface{}) error
+	ContentType() string
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error { return json.NewDecoder(r).Decode(v) }
+func (jsonCodec) Encode(w io.Writer, v interface{}) error { return json.NewEncoder(w).Encode(v) }
+func (jsonCodec) ContentType() string                     { return "application/json" }
+
+// protoCodec marshals using the same github.com/golang/protobuf/proto
+// library the appengine internals use, so generated .pb.go messages work
+// without adaptation.
+type protoCodec struct{}
+
+func (protoCodec) Decode(r io.Reader, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("endpoints: protobuf codec: %T does not implement proto.Message", v)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, msg)
+}
+
+func (protoCodec) Encode(w io.Writer, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("endpoints: protobuf codec: %T does not implement proto.Message", v)
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (protoCodec) ContentType() string { return "application/x-protobuf" }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Decode(r io.Reader, v interface{}) error {
+	return msgpack.NewDecoder(r).Decode(v)
+}
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	return msgpack.NewEncoder(w).Encode(v)
+}
+
+func (msgpackCodec) ContentType() string { return "application/x-msgpack" }
+
+// codecRegistry maps a codec name to its implementation. RegisterCodec adds
+// to it; codecByContentType is kept in sync so content negotiation works for
+// codecs registered after startup too.
+var codecRegistry = map[string]Codec{
+	"json":     jsonCodec{},
+	"protobuf": protoCodec{},
+	"msgpack":  msgpackCodec{},
+}
+
+var codecByContentType = map[string]string{
+	"application/json":       "json",
+	"application/x-protobuf": "protobuf",
+	"application/x-msgpack":  "msgpack",
+}
+
+// RegisterCodec makes a Codec available for content negotiation under name,
+// keyed for lookup by both name and c.ContentType().
+func RegisterCodec(name string, c Codec) {
+	codecRegistry[name] = c
+	codecByContentType[c.ContentType()] = name
+}
+
+// defaultServiceCodec holds the fallback codec name for a Service's
+// EndpointPrefix(), used when a request carries neither a recognized
+// Content-Type nor Accept header.
+var defaultServiceCodec = map[string]string{}
+
+// RegisterDefaultCodec sets the codec used for svc's endpoints when a
+// request doesn't specify a Content-Type or Accept header. Services default
+// to "json" unless this is called.
+func RegisterDefaultCodec(svc Service, name string) {
+	defaultServiceCodec[svc.EndpointPrefix()] = name
+}
+
+// codecFor negotiates the Codec for a request, preferring Content-Type, then
+// Accept, then the service's registered default, then JSON.
+func codecFor(r *http.Request, service interface{}) Codec {
+	if name, ok := codecByContentType[mediaType(r.Header.Get("Content-Type"))]; ok {
+		if c, ok := codecRegistry[name]; ok {
+			return c
+		}
+	}
+	if name, ok := codecByContentType[mediaType(r.Header.Get("Accept"))]; ok {
+		if c, ok := codecRegistry[name]; ok {
+			return c
+		}
+	}
+	if svc, ok := service.(Service); ok {
+		if name, ok := defaultServiceCodec[svc.EndpointPrefix()]; ok {
+			if c, ok := codecRegistry[name]; ok {
+				return c
+			}
+		}
+	}
+	return codecRegistry["json"]
+}
+
+// mediaType strips parameters (e.g. "; charset=UTF-8") from a Content-Type
+// or Accept header value.
+func mediaType(header string) string {
+	if header == "" {
+		return ""
+	}
+	t, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return header
+	}
+	return t
+}
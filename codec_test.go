@@ -0,0 +1,49 @@
+package endpoints
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type stubService struct{}
+
+func (stubService) EndpointPrefix() string { return "/stub" }
+
+func TestCodecForNegotiation(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		accept      string
+		want        string
+	}{
+		{"content-type wins", "application/json", "application/x-protobuf", "application/json"},
+		{"falls back to accept", "", "application/x-msgpack", "application/x-msgpack"},
+		{"defaults to json", "", "", "application/json"},
+		{"unknown content-type falls through to accept", "text/plain", "application/x-protobuf", "application/x-protobuf"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/stub/Method", nil)
+			if c.contentType != "" {
+				r.Header.Set("Content-Type", c.contentType)
+			}
+			if c.accept != "" {
+				r.Header.Set("Accept", c.accept)
+			}
+			codec := codecFor(r, stubService{})
+			if got := codec.ContentType(); got != c.want {
+				t.Errorf("codecFor() content type = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMediaTypeStripsParameters(t *testing.T) {
+	if got := mediaType("application/json; charset=UTF-8"); got != "application/json" {
+		t.Errorf("mediaType() = %q, want application/json", got)
+	}
+	if got := mediaType(""); got != "" {
+		t.Errorf("mediaType(\"\") = %q, want empty string", got)
+	}
+}
@@ -2,7 +2,7 @@ package endpoints
 
 import (
 	"bytes"
-	"encoding/json"
+	"errors"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine"
 	"io"
@@ -44,6 +44,28 @@ type VoidMessage struct {
 
 var typeOfVoidMessage = reflect.TypeOf(new(VoidMessage))
 
+// isNilValue reports whether v is nil, unwrapping interfaces so a typed-nil
+// value boxed inside one (e.g. a nil *HandlerError returned as error) counts
+// as nil too. reflect.Value.IsNil on an interface Kind only reports true for
+// a completely empty interface, not one holding a nil pointer, so callers
+// that need "is this error actually absent" must go through this instead of
+// a bare v.IsNil() or v.Interface() != nil.
+func isNilValue(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return v.IsNil()
+	case reflect.Interface:
+		if v.IsNil() {
+			return true
+		}
+		return isNilValue(v.Elem())
+	}
+	return false
+}
+
 // HTTPRequest returns the request associated with a context.
 func HTTPRequest(c context.Context) *http.Request {
 	r, _ := c.Value(requestKey).(*http.Request)
@@ -65,73 +87,130 @@ type Service interface {
 // The reason for this is because endpoints doesn't support custom domains.
 // I am wrapping the endpoints so we can leave them intact for when this is supported.
 // The Panic in here is only at runtime.
-func EndpointHandlerWrapper(service interface{}, name string) http.HandlerFunc {
-	// using this helper because it gets the Request type and Return type for us
-	reqType := typeOfVoidMessage
+func EndpointHandlerWrapper(service interface{}, name string, opts ...HandlerOptions) http.HandlerFunc {
 	method := reflect.ValueOf(service).MethodByName(name)
 	if !method.IsValid() {
 		log.Printf("method: %s\n", method)
 		log.Panic("bad method")
 	}
+	handler, ok := newEndpointHandler(service, method, authSettings{}, opts...)
+	if !ok {
+		return nil
+	}
+	return handler
+}
+
+// newEndpointHandler builds the http.HandlerFunc for a single bound method,
+// or reports ok == false if method's signature doesn't match the endpoint
+// shape. It's shared by EndpointHandlerWrapper and Server.RegisterService so
+// both validate and dispatch methods the same way.
+func newEndpointHandler(service interface{}, method reflect.Value, authOpts authSettings, opts ...HandlerOptions) (handler http.HandlerFunc, ok bool) {
+	options := defaultHandlerOptions()
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	// using this helper because it gets the Request type and Return type for us
+	reqType := typeOfVoidMessage
 	numIn, numOut := method.Type().NumIn(), method.Type().NumOut()
 	// Endpoint methods one to three arguments and
 	// return either one or two values.
 	if !(1 <= numIn && numIn <= 3 && 1 <= numOut && numOut <= 2) {
-		return nil
+		return nil, false
 	}
 	// The response message is either an input or and output, not both.
 	if numIn == 3 && numOut == 2 {
-		return nil
+		return nil, false
 	}
-	// If there's a request type it's the second argument.
+	// If there's a request type it's the second argument. It's usually a
+	// pointer to a struct decoded from the body, but a method can opt out
+	// of decoding and stream the body instead by taking *http.Request or
+	// io.Reader.
+	streamsBody := false
 	if numIn >= 2 {
-		reqType = method.Type().In(1).Elem()
+		argType := method.Type().In(1)
+		switch argType {
+		case reflect.TypeOf((*http.Request)(nil)), reflect.TypeOf((*io.Reader)(nil)).Elem():
+			streamsBody = true
+		default:
+			reqType = argType.Elem()
+		}
 	}
 	// The last returned value is an error.
 	// errType := method.Type().Out(method.Type().NumOut() - 1)
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		codec := codecFor(r, service)
+		w.Header().Set("Content-Type", codec.ContentType())
 		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		// read in the request
-		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			logAlwaysNoContext(r, "readall")
-			return
-		}
-		if err := r.Body.Close(); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			logAlwaysNoContext(r, "close")
+			writeError(w, r, BadRequest("endpoints only accept POST", nil))
 			return
 		}
 
-		logAlwaysNoContext(r, "request body: %s", body)
-
-		// Restore the body in the original request.
-		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		maxBytes := serviceOptionsFor(service).MaxRequestBytes
+		c := NewContext(r)
 
-		// get a new request Struct and unmarshal it
-		val := reflect.New(reqType)
-		if err := json.Unmarshal(body, val.Interface()); err != nil {
-			w.WriteHeader(422) // unprocessable entity
-			logAlwaysNoContext(r, "unmarshal")
-			if err := json.NewEncoder(w).Encode(err); err != nil {
-				logAlwaysNoContext(r, "encode err")
+		if len(authOpts.authenticators) > 0 || authOpts.required {
+			user, err := authenticate(authOpts.authenticators, c)
+			if err != nil {
+				if authOpts.required {
+					writeError(w, r, Unauthorized("authentication required", err))
+					return
+				}
+			} else {
+				c = context.WithValue(c, authenticatorKey, user)
 			}
-			return
 		}
 
-		// call the Endpoint
-		c := NewContext(r)
 		args := []reflect.Value{reflect.ValueOf(c)}
+
 		if numIn >= 2 {
-			args = append(args, val)
+			if streamsBody {
+				limited := limitBody(r.Body, maxBytes)
+				if method.Type().In(1).Kind() == reflect.Interface {
+					args = append(args, reflect.ValueOf(limited))
+				} else {
+					r.Body = limited
+					args = append(args, reflect.ValueOf(r))
+				}
+			} else {
+				// read in the request
+				body, err := readLimited(r.Body, maxBytes)
+				if err == errBodyTooLarge {
+					writeError(w, r, &HandlerError{Status: http.StatusRequestEntityTooLarge, Code: "request_too_large", Message: "request body too large"})
+					return
+				}
+				if err != nil {
+					logAlwaysNoContext(r, "readall")
+					writeError(w, r, Internal(err))
+					return
+				}
+				if err := r.Body.Close(); err != nil {
+					logAlwaysNoContext(r, "close")
+					writeError(w, r, Internal(err))
+					return
+				}
+
+				logAlwaysNoContext(r, "request body: %s", body)
+
+				// Restore the body in the original request.
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+				// get a new request Struct and decode it
+				val := reflect.New(reqType)
+				if err := codec.Decode(bytes.NewReader(body), val.Interface()); err != nil {
+					logAlwaysNoContext(r, "decode")
+					writeError(w, r, &HandlerError{Status: http.StatusUnprocessableEntity, Code: "invalid_request", Message: "invalid request body", Err: err})
+					return
+				}
+				args = append(args, val)
+			}
+		}
+
+		// call the Endpoint
+		ret, handled := callMethodSafely(w, r, c, method, args, options)
+		if handled {
+			return
 		}
-		ret := method.Call(args)
 
 		var errValue, respValue reflect.Value
 
@@ -143,21 +222,27 @@ func EndpointHandlerWrapper(service interface{}, name string) http.HandlerFunc {
 			errValue = ret[0]
 		}
 
-		// Check if method returned an error
-		if errr := errValue.Interface(); errr != nil {
-			//logAlwaysNoContext(r, "errr")
-			w.WriteHeader(http.StatusInternalServerError)
-			if err := json.NewEncoder(w).Encode(errr); err != nil {
-				logAlwaysNoContext(r, "encode errr")
+		// Check if method returned an error. errValue.Interface() != nil isn't
+		// enough: a typed-nil *HandlerError (a declared-but-unassigned error
+		// var) satisfies that comparison but panics if passed to errors.Is
+		// (through its nil-receiver Unwrap), and reflect.Value.IsNil on an interface only reports
+		// true for a fully nil interface, not one boxing a nil pointer.
+		// isNilValue unwraps the interface to check the boxed value itself.
+		if !isNilValue(errValue) {
+			err, _ := errValue.Interface().(error)
+			if errors.Is(err, errBodyTooLarge) {
+				writeError(w, r, &HandlerError{Status: http.StatusRequestEntityTooLarge, Code: "request_too_large", Message: "request body too large", Err: err})
+				return
 			}
+			writeError(w, r, err)
 			return
 		}
 
 		// encode the response
-		if err := json.NewEncoder(w).Encode(respValue.Interface()); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		if err := codec.Encode(w, respValue.Interface()); err != nil {
 			logAlwaysNoContext(r, "encode ret")
+			writeError(w, r, Internal(err))
 			return
 		}
-	}
+	}, true
 }
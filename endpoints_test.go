@@ -0,0 +1,49 @@
+package endpoints
+
+import (
+	"reflect"
+	"testing"
+)
+
+// This is a regression test for the chunk0-4 fix: a typed-nil *HandlerError
+// returned as the interface type error must be treated as "no error", not
+// passed on to errors.Is/writeError where the nil receiver would panic.
+func TestIsNilValue(t *testing.T) {
+	var nilHandlerErr error = (*HandlerError)(nil)
+	var assignedErr error = &HandlerError{Status: 500}
+	var nilIface error
+
+	cases := []struct {
+		name string
+		v    reflect.Value
+		want bool
+	}{
+		{"typed-nil pointer boxed in error interface", reflect.ValueOf(&nilHandlerErr).Elem(), true},
+		{"empty interface", reflect.ValueOf(&nilIface).Elem(), true},
+		{"non-nil error", reflect.ValueOf(&assignedErr).Elem(), false},
+		{"invalid value", reflect.Value{}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isNilValue(c.v); got != c.want {
+				t.Errorf("isNilValue(%s) = %v, want %v", c.name, got, c.want)
+			}
+		})
+	}
+}
+
+func TestIsNilValueMatchesCallReturn(t *testing.T) {
+	ret := func() error {
+		var herr *HandlerError
+		return herr
+	}
+	out := reflect.ValueOf(ret).Call(nil)
+	errValue := out[0]
+
+	if errValue.Interface() == nil {
+		t.Fatal("sanity check: boxed typed-nil must still compare != nil to the bare interface")
+	}
+	if !isNilValue(errValue) {
+		t.Error("isNilValue should treat a typed-nil *HandlerError as no error")
+	}
+}
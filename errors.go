@@ -0,0 +1,118 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// HandlerError is the error type endpoint methods can return to control the
+// HTTP status code and JSON envelope that EndpointHandlerWrapper writes.
+// Errors that don't implement this type are treated as opaque internal
+// failures and mapped to a 500 with a generic message, so implementation
+// details never leak to the caller.
+type HandlerError struct {
+	Status  int
+	Code    string
+	Message string
+	Details string
+	Err     error
+}
+
+func (e *HandlerError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+// Unwrap allows errors.Is/errors.As to see through to the wrapped error.
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// WithDetails attaches additional, non-sensitive detail to the error's
+// JSON envelope and returns the receiver for chaining.
+func (e *HandlerError) WithDetails(details string) *HandlerError {
+	e.Details = details
+	return e
+}
+
+// NotFound reports that the requested resource doesn't exist.
+func NotFound(message string, err error) *HandlerError {
+	return &HandlerError{Status: http.StatusNotFound, Code: "not_found", Message: message, Err: err}
+}
+
+// Unauthorized reports missing or invalid credentials.
+func Unauthorized(message string, err error) *HandlerError {
+	return &HandlerError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: message, Err: err}
+}
+
+// BadRequest reports a malformed or invalid request.
+func BadRequest(message string, err error) *HandlerError {
+	return &HandlerError{Status: http.StatusBadRequest, Code: "bad_request", Message: message, Err: err}
+}
+
+// Conflict reports that the request can't be completed due to the current
+// state of the resource.
+func Conflict(message string, err error) *HandlerError {
+	return &HandlerError{Status: http.StatusConflict, Code: "conflict", Message: message, Err: err}
+}
+
+// Internal wraps an unexpected error. The wrapped error stays reachable via
+// Error and Unwrap but is never serialized back to the caller; neither
+// Internal nor writeError logs it.
+func Internal(err error) *HandlerError {
+	return &HandlerError{Status: http.StatusInternalServerError, Code: "internal", Message: http.StatusText(http.StatusInternalServerError), Err: err}
+}
+
+// errorEnvelope is the stable JSON shape written for every error response.
+type errorEnvelope struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Code    string `json:"code"`
+	Details string `json:"details,omitempty"`
+	Request string `json:"request,omitempty"`
+}
+
+// requestID extracts the platform-assigned request identifier so it can be
+// threaded through error envelopes for correlation with logs.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Cloud-Trace-Context"); id != "" {
+		return id
+	}
+	return r.Header.Get("X-Appengine-Request-Id")
+}
+
+// writeError renders err as the stable JSON envelope and sets the matching
+// HTTP status code. Errors implementing *HandlerError drive the status,
+// code and message; everything else is treated as an internal error and
+// reduced to http.StatusText so callers never see raw Go error internals.
+//
+// The envelope is always JSON, even when the request negotiated a binary
+// codec (protobuf, msgpack) for the success path: the error contract is
+// part of this package's stable API, not something every Codec needs to
+// implement.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	he, ok := err.(*HandlerError)
+	if !ok || he == nil {
+		// A typed-nil *HandlerError (e.g. a declared-but-unassigned var
+		// returned as the method's error) satisfies the type assertion but
+		// would panic on he.Status below.
+		he = Internal(err)
+	}
+	env := errorEnvelope{
+		Status:  he.Status,
+		Error:   http.StatusText(he.Status),
+		Message: he.Message,
+		Code:    he.Code,
+		Details: he.Details,
+		Request: requestID(r),
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(he.Status)
+	if encErr := json.NewEncoder(w).Encode(env); encErr != nil {
+		logAlwaysNoContext(r, "encode errr")
+	}
+}
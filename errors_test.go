@@ -0,0 +1,57 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteErrorHandlerError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/stub/Method", nil)
+	w := httptest.NewRecorder()
+
+	writeError(w, r, BadRequest("bad input", nil).WithDetails("field \"name\" is required"))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var env errorEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	if env.Code != "bad_request" || env.Message != "bad input" || env.Details != `field "name" is required` {
+		t.Errorf("envelope = %+v", env)
+	}
+}
+
+func TestWriteErrorTypedNilHandlerError(t *testing.T) {
+	var herr *HandlerError
+	r := httptest.NewRequest(http.MethodPost, "/stub/Method", nil)
+	w := httptest.NewRecorder()
+
+	writeError(w, r, herr)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestWriteErrorOpaqueError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/stub/Method", nil)
+	w := httptest.NewRecorder()
+
+	writeError(w, r, errors.New("boom"))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	var env errorEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	if env.Code != "internal" {
+		t.Errorf("opaque error should map to the generic internal code, got %q", env.Code)
+	}
+}
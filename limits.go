@@ -0,0 +1,107 @@
+package endpoints
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// defaultMaxRequestBytes is applied to services that haven't called
+// RegisterService, and to those whose ServiceOptions leave MaxRequestBytes
+// at zero.
+const defaultMaxRequestBytes = 1048576 // 1 MiB
+
+// errBodyTooLarge is returned by a maxBytesReader once its caller has read
+// past the configured limit.
+var errBodyTooLarge = errors.New("endpoints: request body too large")
+
+// ServiceOptions configures per-service behavior for EndpointHandlerWrapper.
+type ServiceOptions struct {
+	// MaxRequestBytes caps how much of the request body is read before
+	// dispatch. Zero means the 1 MiB default; -1 means unlimited.
+	MaxRequestBytes int64
+
+	// AuthRequired overrides, per method name, whether Server.RegisterService
+	// requires authentication for that endpoint. Methods absent from the map
+	// default to required when the service was registered with at least one
+	// Authenticator, and to public otherwise.
+	AuthRequired map[string]bool
+}
+
+func defaultServiceOptions() ServiceOptions {
+	return ServiceOptions{MaxRequestBytes: defaultMaxRequestBytes}
+}
+
+// serviceOptionsByPrefix holds the options passed to RegisterService, keyed
+// by the service's EndpointPrefix(), mirroring defaultServiceCodec.
+var serviceOptionsByPrefix = map[string]ServiceOptions{}
+
+// RegisterService associates opts with s for the lifetime of the process.
+// EndpointHandlerWrapper looks these up by s.EndpointPrefix() to decide how
+// much of an incoming request body it's willing to read.
+func RegisterService(s Service, opts ServiceOptions) {
+	serviceOptionsByPrefix[s.EndpointPrefix()] = opts
+}
+
+func serviceOptionsFor(service interface{}) ServiceOptions {
+	if svc, ok := service.(Service); ok {
+		if opts, ok := serviceOptionsByPrefix[svc.EndpointPrefix()]; ok {
+			if opts.MaxRequestBytes == 0 {
+				opts.MaxRequestBytes = defaultMaxRequestBytes
+			}
+			return opts
+		}
+	}
+	return defaultServiceOptions()
+}
+
+// maxBytesReader mirrors the stdlib's http.MaxBytesReader: it reads through
+// to r, but once more than n bytes have been read it stops short and fails
+// with errBodyTooLarge instead of silently truncating like io.LimitReader.
+type maxBytesReader struct {
+	r   io.ReadCloser
+	n   int64
+	err error
+}
+
+func (l *maxBytesReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) <= l.n {
+		l.n -= int64(n)
+		l.err = err
+		return n, err
+	}
+	n = int(l.n)
+	l.n = 0
+	l.err = errBodyTooLarge
+	return n, l.err
+}
+
+func (l *maxBytesReader) Close() error { return l.r.Close() }
+
+// limitBody wraps body so reads past max fail with errBodyTooLarge. A
+// negative max means unlimited, in which case body is returned unwrapped.
+func limitBody(body io.ReadCloser, max int64) io.ReadCloser {
+	if max < 0 {
+		return body
+	}
+	return &maxBytesReader{r: body, n: max}
+}
+
+// readLimited reads all of body, failing with errBodyTooLarge if it exceeds
+// max bytes. A negative max means unlimited.
+func readLimited(body io.ReadCloser, max int64) ([]byte, error) {
+	if max < 0 {
+		return ioutil.ReadAll(body)
+	}
+	return ioutil.ReadAll(&maxBytesReader{r: body, n: max})
+}
@@ -0,0 +1,59 @@
+package endpoints
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestReadLimited(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string
+		max     int64
+		want    string
+		wantErr error
+	}{
+		{"under limit", "hello", 10, "hello", nil},
+		{"exactly at limit", "hello", 5, "hello", nil},
+		{"over limit", "hello world", 5, "", errBodyTooLarge},
+		{"unlimited", "hello world", -1, "hello world", nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			body := ioutil.NopCloser(strings.NewReader(c.body))
+			got, err := readLimited(body, c.max)
+			if c.wantErr != nil {
+				if !errors.Is(err, c.wantErr) {
+					t.Fatalf("readLimited() err = %v, want %v", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readLimited() unexpected err = %v", err)
+			}
+			if string(got) != c.want {
+				t.Errorf("readLimited() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLimitBodyUnlimited(t *testing.T) {
+	body := ioutil.NopCloser(strings.NewReader("hello"))
+	limited := limitBody(body, -1)
+	if limited != io.ReadCloser(body) {
+		t.Error("limitBody(-1) should return body unwrapped")
+	}
+}
+
+func TestMaxBytesReaderStopsShortNotTruncated(t *testing.T) {
+	body := ioutil.NopCloser(strings.NewReader("hello world"))
+	limited := limitBody(body, 5)
+	_, err := ioutil.ReadAll(limited)
+	if !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("ReadAll() err = %v, want errBodyTooLarge", err)
+	}
+}
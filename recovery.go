@@ -0,0 +1,102 @@
+package endpoints
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	applog "google.golang.org/appengine/log"
+)
+
+// maxPanicStackSize bounds how much of the goroutine stack gets logged when
+// an endpoint method panics.
+const maxPanicStackSize = 64 * 1024
+
+// HandlerOptions configures the behavior of EndpointHandlerWrapper.
+type HandlerOptions struct {
+	// WithRecovery recovers panics raised by the dispatched endpoint method
+	// and converts them into a 500 response instead of crashing the request
+	// goroutine (and, on App Engine, the instance). Defaults to true; tests
+	// that want panics to propagate normally can disable it.
+	WithRecovery bool
+}
+
+func defaultHandlerOptions() HandlerOptions {
+	return HandlerOptions{WithRecovery: true}
+}
+
+// callMethodSafely calls method with args, optionally recovering from a
+// panic. When a panic is recovered and handled (by logging it and writing an
+// error response), handled is true and the caller must not write anything
+// else to w.
+func callMethodSafely(w http.ResponseWriter, r *http.Request, c context.Context, method reflect.Value, args []reflect.Value, opts HandlerOptions) (ret []reflect.Value, handled bool) {
+	if !opts.WithRecovery {
+		return method.Call(args), false
+	}
+	// handlePanic must be the deferred function itself for its recover() to
+	// take effect. handled stays true only if method.Call never returns.
+	handled = true
+	defer handlePanic(c, w, r)
+	ret = method.Call(args)
+	return ret, false
+}
+
+// Recovery returns a Middleware that recovers panics from the wrapped
+// Handler, the same way HandlerOptions.WithRecovery does for a single
+// dispatched method, but applied to the whole request — codec negotiation,
+// body reads and auth included, not just the reflected method call.
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := NewContext(r)
+			defer handlePanic(c, w, r)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// handlePanic recovers a panic, if any, logs it, and writes the error
+// envelope to w. It reports whether a panic was recovered.
+func handlePanic(c context.Context, w http.ResponseWriter, r *http.Request) bool {
+	rec := recover()
+	if rec == nil {
+		return false
+	}
+	if rec == http.ErrAbortHandler || isBrokenPipe(rec) {
+		logAlways(c, "endpoints: aborted handler or broken pipe: %v", rec)
+		return true
+	}
+	buf := make([]byte, maxPanicStackSize)
+	n := runtime.Stack(buf, false)
+	applog.Criticalf(c, "endpoints: panic recovered: %v\n%s", rec, buf[:n])
+	writeError(w, r, Internal(fmt.Errorf("panic: %v", rec)))
+	return true
+}
+
+// isBrokenPipe reports whether a recovered panic value is the client having
+// gone away (broken pipe / connection reset), which isn't worth a 500 or a
+// loud log line since nothing can be written back to the client anyway.
+func isBrokenPipe(rec interface{}) bool {
+	err, ok := rec.(error)
+	if !ok {
+		return false
+	}
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	var msg string
+	if se, ok := opErr.Err.(*os.SyscallError); ok {
+		msg = se.Error()
+	} else {
+		msg = opErr.Err.Error()
+	}
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+}
@@ -0,0 +1,103 @@
+package endpoints
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+)
+
+// Handler is the http.Handler type endpoints are dispatched through. It's an
+// alias so middleware reads naturally alongside the standard library.
+type Handler = http.Handler
+
+// Middleware wraps a Handler to add cross-cutting behavior (logging, rate
+// limiting, panic recovery) to every endpoint registered on a Server.
+type Middleware func(Handler) Handler
+
+// Server reflects over registered Services once, at RegisterService time,
+// and serves their endpoint methods at EndpointPrefix()+"/"+MethodName.
+// Callers that still want to wire a single method into their own mux can
+// keep using EndpointHandlerWrapper; Server exists so most services don't
+// have to.
+//
+// Panic recovery is wired through the Middleware chain (see Recovery, added
+// by default below) since it applies uniformly to every request. Auth is
+// per-method instead: RegisterService takes the Authenticators and looks up
+// each method's ServiceOptions.AuthRequired, so some endpoints on a service
+// can be public while others require credentials — a generic Middleware
+// can't see that distinction, only RegisterService can.
+type Server struct {
+	mux        *http.ServeMux
+	middleware []Middleware
+}
+
+// NewServer returns a Server ready for RegisterService calls, with panic
+// recovery already installed via Use(Recovery()).
+func NewServer() *Server {
+	s := &Server{mux: http.NewServeMux()}
+	s.Use(Recovery())
+	return s
+}
+
+// Use appends mw to the middleware chain applied to every endpoint
+// registered on s. Middleware runs in the order it was added, outermost
+// first.
+func (s *Server) Use(mw Middleware) {
+	s.middleware = append(s.middleware, mw)
+}
+
+// RegisterService reflects over every exported method of svc, mounts the
+// ones matching the endpoint signature at EndpointPrefix()+"/"+MethodName,
+// and validates them up front instead of at first request. It returns an
+// error if svc has no endpoint methods at all, which usually means the
+// signatures don't match what EndpointHandlerWrapper expects.
+//
+// If authenticators are given, they run in order before each dispatched
+// method; a method is public instead of 401ing on missing or invalid
+// credentials if svc's registered ServiceOptions.AuthRequired marks it
+// false.
+func (s *Server) RegisterService(svc Service, authenticators ...Authenticator) error {
+	v := reflect.ValueOf(svc)
+	t := v.Type()
+	prefix := svc.EndpointPrefix()
+	authRequired := serviceOptionsFor(svc).AuthRequired
+
+	registered := 0
+	for i := 0; i < t.NumMethod(); i++ {
+		name := t.Method(i).Name
+		authOpts := authSettings{
+			authenticators: authenticators,
+			required:       len(authenticators) > 0,
+		}
+		if required, ok := authRequired[name]; ok {
+			authOpts.required = required
+		}
+		// Recovery is already installed as middleware (see NewServer), so
+		// don't also recover inline around the reflected method call.
+		handler, ok := newEndpointHandler(svc, v.Method(i), authOpts, HandlerOptions{WithRecovery: false})
+		if !ok {
+			log.Printf("endpoints: %s: skipping %s: signature doesn't match the endpoint shape", prefix, name)
+			continue
+		}
+		s.mux.Handle(prefix+"/"+name, s.chain(handler))
+		registered++
+	}
+	if registered == 0 {
+		return fmt.Errorf("endpoints: %s: no endpoint methods found", prefix)
+	}
+	return nil
+}
+
+// chain wraps h with s.middleware, outermost middleware first.
+func (s *Server) chain(h Handler) Handler {
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	return h
+}
+
+// ServeHTTP implements http.Handler, dispatching to the registered services.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}